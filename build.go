@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/podman/v3/pkg/bindings/images"
+	"github.com/containers/storage/pkg/archive"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// parseBuildArgs turns a "KEY=VALUE,KEY2=VALUE2" flag value into a map.
+func parseBuildArgs(raw string) map[string]string {
+	args := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			log.Warnf("Ignoring malformed --build-arg entry %q", pair)
+			continue
+		}
+		args[kv[0]] = kv[1]
+	}
+	return args
+}
+
+// writeContainerfile generates a small Containerfile in dir that FROMs the
+// pulled base image and adds a timestamped LABEL plus a RUN echo.
+func writeContainerfile(dir string, target *Target) (string, error) {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	contents := fmt.Sprintf(
+		"FROM %s\nLABEL monitor.timestamp=%q\nRUN echo %q > /etc/monitor-marker\n",
+		fullImageRef(target.Registry, target.Repository, target.BaseImage), timestamp, timestamp,
+	)
+
+	path := filepath.Join(dir, "Containerfile")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// tarBuildContext tars dir the same way podman's build binding does
+// internally, so the tar time can be measured on its own.
+func tarBuildContext(dir string) error {
+	tarball, err := archive.Tar(dir, archive.Uncompressed)
+	if err != nil {
+		return err
+	}
+	defer tarball.Close()
+
+	_, err = ioutil.ReadAll(tarball)
+	return err
+}
+
+// buildAndPushImage tars the build context, builds the Containerfile, and
+// pushes the result, recording per-stage durations into result.
+func buildAndPushImage(sinks []MetricsSink, podmanContext context.Context, target *Target, result *RunResult) bool {
+	labels := target.labels()
+
+	tarStart := time.Now()
+	if err := tarBuildContext(*buildContextDir); err != nil {
+		log.Errorf("Error tarring build context %s: %s", *buildContextDir, err)
+		result.Durations["context_tar"] = time.Since(tarStart).String()
+		result.Error = "build context tar failed"
+		return false
+	}
+	result.Durations["context_tar"] = time.Since(tarStart).String()
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_build_context_tar", labels, time.Since(tarStart))
+	}
+
+	containerfilePath, err := writeContainerfile(*buildContextDir, target)
+	if err != nil {
+		log.Errorf("Error writing Containerfile: %s", err)
+		result.Error = "writing Containerfile failed"
+		return false
+	}
+
+	buildStart := time.Now()
+	buildOptions := images.BuildOptions{
+		ContextDirectory: *buildContextDir,
+		Output:           fullImageRef(target.Registry, target.Repository, ""),
+		Args:             parseBuildArgs(*buildArgsFlag),
+		Platform:         *buildPlatform,
+		Squash:           *squashBuild,
+	}
+	if _, err := images.Build(podmanContext, []string{containerfilePath}, buildOptions); err != nil {
+		log.Errorf("Build Error: %s", err)
+		result.Durations["build"] = time.Since(buildStart).String()
+		result.Error = "build failed"
+		return false
+	}
+	result.Durations["build"] = time.Since(buildStart).String()
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_build", labels, time.Since(buildStart))
+	}
+
+	pushStart := time.Now()
+	if !pushTestImage(podmanContext, target) {
+		result.Durations["push"] = time.Since(pushStart).String()
+		result.Error = "push failed"
+		return false
+	}
+	result.Durations["push"] = time.Since(pushStart).String()
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_build_push", labels, time.Since(pushStart))
+	}
+
+	return true
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBuildArgs(t *testing.T) {
+	got := parseBuildArgs("KEY=VALUE, EMPTY=, BAD, ")
+	want := map[string]string{
+		"KEY":   "VALUE",
+		"EMPTY": "",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBuildArgs = %#v, want %#v", got, want)
+	}
+
+	if got := parseBuildArgs(""); len(got) != 0 {
+		t.Errorf("parseBuildArgs(\"\") = %#v, want empty map", got)
+	}
+}
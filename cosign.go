@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/containers/podman/v3/pkg/bindings/images"
+	"github.com/containers/storage/pkg/archive"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// signatureTagSuffix is the cosign convention for a signature's tag: the
+// digest it signs with ':' replaced by '-', suffixed ".sig".
+const signatureTagSuffix = ".sig"
+
+// applySignaturePolicy points pullOptions at the configured policy.json.
+// No-op unless --verify-signatures and --policy-path are set.
+func applySignaturePolicy(pullOptions *images.PullOptions) {
+	if !*verifySignatures || *policyPath == "" {
+		return
+	}
+	pullOptions.SignaturePolicy = policyPath
+}
+
+// signatureTagFor returns the "sha256-<digest>.sig" tag cosign uses.
+func signatureTagFor(repository, digest string) string {
+	trimmed := strings.TrimPrefix(digest, "sha256:")
+	return fmt.Sprintf("%s:sha256-%s%s", repository, trimmed, signatureTagSuffix)
+}
+
+// digestOfPushedImage inspects the just-pushed image to learn its digest.
+func digestOfPushedImage(podmanContext context.Context, source string) (string, error) {
+	data, err := images.GetImage(podmanContext, source, nil)
+	if err != nil {
+		return "", err
+	}
+	return data.Digest, nil
+}
+
+// signatureVerifyReason enumerates why monitor_signature_verify_failure
+// was recorded.
+type signatureVerifyReason string
+
+const (
+	reasonMissing           signatureVerifyReason = "missing"
+	reasonBadSignature      signatureVerifyReason = "bad_signature"
+	reasonUntrustedIdentity signatureVerifyReason = "untrusted_identity"
+)
+
+// ephemeralSigningKey generates a fresh P-256 key pair for a single run.
+func ephemeralSigningKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// signDigest produces a detached signature over the image digest.
+func signDigest(key *ecdsa.PrivateKey, digest string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(digest))
+	return ecdsa.SignASN1(rand.Reader, key, hash[:])
+}
+
+// loadCosignPublicKey reads the PEM-encoded public key configured via
+// --cosign-public-key.
+func loadCosignPublicKey(path string) (*ecdsa.PublicKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key in %s is not ECDSA", path)
+	}
+
+	return ecdsaKey, nil
+}
+
+// verifyAgainstFulcioRoot checks cert chains to the configured Fulcio root.
+func verifyAgainstFulcioRoot(rootPath string, cert *x509.Certificate) error {
+	raw, err := ioutil.ReadFile(rootPath)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return fmt.Errorf("no certificates found in Fulcio root %s", rootPath)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool})
+	return err
+}
+
+// verifySignatureRoundTrip signs the pushed image with an ephemeral key,
+// pushes that signature to the .sig tag, then pulls it back and verifies
+// it against --cosign-public-key (or --fulcio-root). With neither flag set,
+// fetching the signature back is the only check made.
+func verifySignatureRoundTrip(sinks []MetricsSink, podmanContext context.Context, target *Target, result *RunResult) bool {
+	labels := target.labels()
+	start := time.Now()
+
+	source := fullImageRef(target.Registry, target.Repository, "")
+	digest, err := digestOfPushedImage(podmanContext, source)
+	if err != nil {
+		log.Errorf("Error inspecting pushed image for signing: %s", err)
+		recordSignatureFailure(sinks, labels, reasonMissing)
+		result.Durations["signature_verify"] = time.Since(start).String()
+		return false
+	}
+
+	key, err := ephemeralSigningKey()
+	if err != nil {
+		log.Errorf("Error generating ephemeral signing key: %s", err)
+		recordSignatureFailure(sinks, labels, reasonMissing)
+		result.Durations["signature_verify"] = time.Since(start).String()
+		return false
+	}
+
+	signature, err := signDigest(key, digest)
+	if err != nil {
+		log.Errorf("Error signing pushed image digest: %s", err)
+		recordSignatureFailure(sinks, labels, reasonMissing)
+		result.Durations["signature_verify"] = time.Since(start).String()
+		return false
+	}
+
+	signatureTag := signatureTagFor(target.Repository, digest)
+	if !pushSignature(podmanContext, target, signatureTag, signature) {
+		recordSignatureFailure(sinks, labels, reasonMissing)
+		result.Durations["signature_verify"] = time.Since(start).String()
+		return false
+	}
+
+	fetched, err := fetchSignature(podmanContext, target, signatureTag)
+	if err != nil {
+		log.Warnf("Signature tag %s not reachable after push: %s", signatureTag, err)
+		recordSignatureFailure(sinks, labels, reasonMissing)
+		result.Durations["signature_verify"] = time.Since(start).String()
+		return false
+	}
+
+	if *cosignPublicKey != "" {
+		pub, err := loadCosignPublicKey(*cosignPublicKey)
+		if err != nil {
+			log.Errorf("Error loading --cosign-public-key: %s", err)
+			recordSignatureFailure(sinks, labels, reasonBadSignature)
+			result.Durations["signature_verify"] = time.Since(start).String()
+			return false
+		}
+
+		hash := sha256.Sum256([]byte(digest))
+		if !ecdsa.VerifyASN1(pub, hash[:], fetched) {
+			recordSignatureFailure(sinks, labels, reasonBadSignature)
+			result.Durations["signature_verify"] = time.Since(start).String()
+			return false
+		}
+	} else if *fulcioRoot != "" {
+		cert, err := x509.ParseCertificate(fetched)
+		if err != nil {
+			log.Errorf("Error parsing signing certificate: %s", err)
+			recordSignatureFailure(sinks, labels, reasonBadSignature)
+			result.Durations["signature_verify"] = time.Since(start).String()
+			return false
+		}
+
+		if err := verifyAgainstFulcioRoot(*fulcioRoot, cert); err != nil {
+			log.Warnf("Signing certificate did not chain to --fulcio-root: %s", err)
+			recordSignatureFailure(sinks, labels, reasonUntrustedIdentity)
+			result.Durations["signature_verify"] = time.Since(start).String()
+			return false
+		}
+	}
+
+	result.Durations["signature_verify"] = time.Since(start).String()
+	for _, sink := range sinks {
+		sink.IncCounter("monitor_signature_verify_success", labels, 1)
+	}
+	return true
+}
+
+func recordSignatureFailure(sinks []MetricsSink, labels map[string]string, reason signatureVerifyReason) {
+	for _, sink := range sinks {
+		sink.IncCounter("monitor_signature_verify_failure", mergeLabels(labels, map[string]string{"reason": string(reason)}), 1)
+	}
+}
+
+// signatureArchiveEntry is the file name the signature payload is stored
+// under inside the imported .sig image.
+const signatureArchiveEntry = "signature.sig"
+
+// tarSignaturePayload wraps signature in a one-entry tar archive, the
+// shape images.Import expects.
+func tarSignaturePayload(signature []byte) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: signatureArchiveEntry,
+		Mode: 0644,
+		Size: int64(len(signature)),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(signature); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &buf, nil
+}
+
+// pushSignature imports the raw signature bytes as a new single-layer
+// image tagged signatureTag and pushes it.
+func pushSignature(podmanContext context.Context, target *Target, signatureTag string, signature []byte) bool {
+	ref := fmt.Sprintf("%s/%s", target.Registry, signatureTag)
+
+	payload, err := tarSignaturePayload(signature)
+	if err != nil {
+		log.Errorf("Error building signature archive: %s", err)
+		return false
+	}
+
+	if _, err := images.Import(podmanContext, payload, &images.ImportOptions{Reference: &ref}); err != nil {
+		log.Errorf("Error importing signature image %s: %s", ref, err)
+		return false
+	}
+
+	pushOptions := &images.PushOptions{
+		Username: &target.Username,
+		Password: &target.Password,
+	}
+	if err := images.Push(podmanContext, ref, ref, pushOptions); err != nil {
+		log.Errorf("Error pushing signature tag %s: %s", signatureTag, err)
+		return false
+	}
+
+	return true
+}
+
+// fetchSignature pulls the signature tag back, exports the image, and
+// extracts the raw payload pushSignature imported.
+func fetchSignature(podmanContext context.Context, target *Target, signatureTag string) ([]byte, error) {
+	ref := fmt.Sprintf("%s/%s", target.Registry, signatureTag)
+	pullOptions := &images.PullOptions{
+		Username: &target.Username,
+		Password: &target.Password,
+	}
+
+	if _, err := images.Pull(podmanContext, ref, pullOptions); err != nil {
+		return nil, err
+	}
+
+	var exported bytes.Buffer
+	if err := images.Export(podmanContext, []string{ref}, &exported, &images.ExportOptions{}); err != nil {
+		return nil, err
+	}
+
+	return extractFileFromImageArchive(&exported, signatureArchiveEntry)
+}
+
+// extractFileFromImageArchive walks an exported image archive (manifest.json
+// plus one tar per layer) for filename's contents.
+func extractFileFromImageArchive(r io.Reader, filename string) ([]byte, error) {
+	outer := tar.NewReader(r)
+	for {
+		header, err := outer.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		layerStream, err := archive.DecompressStream(outer)
+		if err != nil {
+			continue
+		}
+
+		data, found := findFileInTar(layerStream, filename)
+		layerStream.Close()
+		if found {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found in image archive", filename)
+}
+
+// findFileInTar scans a tar stream for filename and returns its contents.
+func findFileInTar(r io.Reader, filename string) ([]byte, bool) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return nil, false
+		}
+		if header.Name != filename {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, false
+		}
+		return data, true
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func TestSignatureTagFor(t *testing.T) {
+	got := signatureTagFor("acme/app", "sha256:abcd1234")
+	want := "acme/app:sha256-abcd1234.sig"
+	if got != want {
+		t.Errorf("signatureTagFor = %q, want %q", got, want)
+	}
+}
+
+func TestTarSignaturePayload(t *testing.T) {
+	signature := []byte("fake-signature-bytes")
+
+	r, err := tarSignaturePayload(signature)
+	if err != nil {
+		t.Fatalf("tarSignaturePayload: %v", err)
+	}
+
+	data, found := findFileInTar(r, signatureArchiveEntry)
+	if !found {
+		t.Fatalf("tarSignaturePayload did not produce a %q entry", signatureArchiveEntry)
+	}
+	if !bytes.Equal(data, signature) {
+		t.Errorf("tarSignaturePayload entry = %q, want %q", data, signature)
+	}
+}
+
+// buildFakeImageArchive constructs a minimal "exported image" tar whose one
+// layer is itself a tar containing filename, mirroring the shape
+// extractFileFromImageArchive expects to unpack.
+func buildFakeImageArchive(t *testing.T, filename string, content []byte) []byte {
+	t.Helper()
+
+	var layer bytes.Buffer
+	lw := tar.NewWriter(&layer)
+	if err := lw.WriteHeader(&tar.Header{Name: filename, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatalf("write layer header: %v", err)
+	}
+	if _, err := lw.Write(content); err != nil {
+		t.Fatalf("write layer content: %v", err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("close layer writer: %v", err)
+	}
+
+	var outer bytes.Buffer
+	ow := tar.NewWriter(&outer)
+	layerBytes := layer.Bytes()
+	if err := ow.WriteHeader(&tar.Header{Name: "layer.tar", Mode: 0644, Size: int64(len(layerBytes))}); err != nil {
+		t.Fatalf("write outer header: %v", err)
+	}
+	if _, err := ow.Write(layerBytes); err != nil {
+		t.Fatalf("write outer content: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("close outer writer: %v", err)
+	}
+
+	return outer.Bytes()
+}
+
+func TestExtractFileFromImageArchive(t *testing.T) {
+	signature := []byte("round-tripped-signature")
+	archiveBytes := buildFakeImageArchive(t, signatureArchiveEntry, signature)
+
+	got, err := extractFileFromImageArchive(bytes.NewReader(archiveBytes), signatureArchiveEntry)
+	if err != nil {
+		t.Fatalf("extractFileFromImageArchive: %v", err)
+	}
+	if !bytes.Equal(got, signature) {
+		t.Errorf("extractFileFromImageArchive = %q, want %q", got, signature)
+	}
+
+	if _, err := extractFileFromImageArchive(bytes.NewReader(archiveBytes), "missing.txt"); err == nil {
+		t.Error("extractFileFromImageArchive should error when the file isn't present")
+	}
+}
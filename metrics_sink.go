@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsSink abstracts where the monitor's counters and durations get
+// reported to.
+type MetricsSink interface {
+	IncCounter(name string, labels map[string]string, val float64)
+	ObserveDuration(name string, labels map[string]string, dur time.Duration)
+}
+
+// buildMetricsSinks constructs the sinks requested via --metrics-sink,
+// skipping an unconfigured or unknown sink with a warning.
+func buildMetricsSinks(names []string, cloudwatchService *cloudwatch.CloudWatch) []MetricsSink {
+	var sinks []MetricsSink
+
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "prometheus":
+			sinks = append(sinks, prometheusSink{})
+		case "cloudwatch":
+			if cloudwatchService == nil {
+				log.Warnf("metrics-sink cloudwatch requested but CloudWatch is not configured; skipping")
+				continue
+			}
+			sinks = append(sinks, &cloudWatchSink{service: cloudwatchService})
+		case "otlp":
+			if *otlpEndpoint == "" {
+				log.Warnf("metrics-sink otlp requested but --otlp-endpoint is not set; skipping")
+				continue
+			}
+			sink, err := newOTLPSink(context.Background(), *otlpEndpoint, *otlpInsecure)
+			if err != nil {
+				log.Errorf("Failed to configure OTLP metrics sink: %s", err)
+				continue
+			}
+			sinks = append(sinks, sink)
+		case "statsd":
+			if *statsdAddress == "" {
+				log.Warnf("metrics-sink statsd requested but --statsd-address is not set; skipping")
+				continue
+			}
+			sink, err := newStatsdSink(*statsdAddress)
+			if err != nil {
+				log.Errorf("Failed to configure StatsD metrics sink: %s", err)
+				continue
+			}
+			sinks = append(sinks, sink)
+		default:
+			log.Warnf("Unknown metrics sink %q", name)
+		}
+	}
+
+	return sinks
+}
+
+// prometheusSink reports into the package's existing prometheus collectors.
+type prometheusSink struct{}
+
+func (prometheusSink) IncCounter(name string, labels map[string]string, val float64) {
+	switch name {
+	case "monitor_success":
+		promSuccessMetric.WithLabelValues(labels["registry"], labels["repository"]).Add(val)
+	case "monitor_failure":
+		promFailureMetric.WithLabelValues(labels["registry"], labels["repository"]).Add(val)
+	case "monitor_manifest_arch_missing":
+		promManifestArchMissingMetric.WithLabelValues(labels["registry"], labels["repository"], labels["arch"]).Add(val)
+	case "monitor_signature_verify_success":
+		promSignatureVerifySuccessMetric.WithLabelValues(labels["registry"], labels["repository"]).Add(val)
+	case "monitor_signature_verify_failure":
+		promSignatureVerifyFailureMetric.WithLabelValues(labels["registry"], labels["repository"], labels["reason"]).Add(val)
+	default:
+		log.Warnf("prometheus sink: unknown counter metric %q", name)
+	}
+}
+
+func (prometheusSink) ObserveDuration(name string, labels map[string]string, dur time.Duration) {
+	switch name {
+	case "monitor_pull":
+		promPullMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	case "monitor_push":
+		promPushMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	case "monitor_manifest_push":
+		promManifestPushMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	case "monitor_build_context_tar":
+		promBuildContextTarMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	case "monitor_build":
+		promBuildMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	case "monitor_build_push":
+		promBuildPushMetric.WithLabelValues(labels["registry"], labels["repository"]).Observe(dur.Seconds())
+	default:
+		log.Warnf("prometheus sink: unknown duration metric %q", name)
+	}
+}
+
+// cloudWatchSink reports into putCloudWatchMetric, translating the generic
+// metric name into the configured CloudWatch metric name.
+type cloudWatchSink struct {
+	service *cloudwatch.CloudWatch
+}
+
+func cloudWatchMetricName(name string) string {
+	switch name {
+	case "monitor_success":
+		return *cloudwatchSuccessMetric
+	case "monitor_failure":
+		return *cloudwatchFailureMetric
+	case "monitor_pull":
+		return *cloudwatchPullTimeMetric
+	case "monitor_push":
+		return *cloudwatchPushTimeMetric
+	default:
+		return ""
+	}
+}
+
+func (s *cloudWatchSink) IncCounter(name string, labels map[string]string, val float64) {
+	metricName := cloudWatchMetricName(name)
+	if metricName == "" {
+		return
+	}
+	putCloudWatchMetric(metricName, s.service, "Count", val, labels)
+}
+
+func (s *cloudWatchSink) ObserveDuration(name string, labels map[string]string, dur time.Duration) {
+	metricName := cloudWatchMetricName(name)
+	if metricName == "" {
+		return
+	}
+	putCloudWatchMetric(metricName, s.service, "Seconds", dur.Seconds(), labels)
+}
+
+// otlpSink exports counters and durations over OTLP, tagging each metric
+// with attributesFromLabels rather than fixed resource attributes.
+type otlpSink struct {
+	meter      metric.Meter
+	mu         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	histograms map[string]metric.Float64Histogram
+}
+
+func newOTLPSink(ctx context.Context, endpoint string, insecure bool) (*otlpSink, error) {
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+
+	exporter, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	return &otlpSink{
+		meter:      provider.Meter("registry-monitor"),
+		counters:   map[string]metric.Float64Counter{},
+		histograms: map[string]metric.Float64Histogram{},
+	}, nil
+}
+
+func (s *otlpSink) counter(name string) metric.Float64Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.counters[name]; ok {
+		return c
+	}
+
+	c, err := s.meter.Float64Counter(name)
+	if err != nil {
+		log.Errorf("otlp sink: failed to create counter %s: %s", name, err)
+		return nil
+	}
+	s.counters[name] = c
+	return c
+}
+
+func (s *otlpSink) histogram(name string) metric.Float64Histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h, ok := s.histograms[name]; ok {
+		return h
+	}
+
+	h, err := s.meter.Float64Histogram(name, metric.WithUnit("s"))
+	if err != nil {
+		log.Errorf("otlp sink: failed to create histogram %s: %s", name, err)
+		return nil
+	}
+	s.histograms[name] = h
+	return h
+}
+
+func attributesFromLabels(labels map[string]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	return attrs
+}
+
+func (s *otlpSink) IncCounter(name string, labels map[string]string, val float64) {
+	c := s.counter(name)
+	if c == nil {
+		return
+	}
+	c.Add(context.Background(), val, metric.WithAttributes(attributesFromLabels(labels)...))
+}
+
+func (s *otlpSink) ObserveDuration(name string, labels map[string]string, dur time.Duration) {
+	h := s.histogram(name)
+	if h == nil {
+		return
+	}
+	h.Record(context.Background(), dur.Seconds(), metric.WithAttributes(attributesFromLabels(labels)...))
+}
+
+// statsdSink reports into a StatsD/DogStatsD agent, turning labels into tags.
+type statsdSink struct {
+	client *statsd.Client
+}
+
+func newStatsdSink(address string) (*statsdSink, error) {
+	client, err := statsd.New(address)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{client: client}, nil
+}
+
+func tagsFromLabels(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, fmt.Sprintf("%s:%s", k, v))
+	}
+	return tags
+}
+
+func (s *statsdSink) IncCounter(name string, labels map[string]string, val float64) {
+	if err := s.client.Count(name, int64(val), tagsFromLabels(labels), 1); err != nil {
+		log.Errorf("statsd sink: failed to report counter %s: %s", name, err)
+	}
+}
+
+func (s *statsdSink) ObserveDuration(name string, labels map[string]string, dur time.Duration) {
+	if err := s.client.Timing(name, dur, tagsFromLabels(labels), 1); err != nil {
+		log.Errorf("statsd sink: failed to report duration %s: %s", name, err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCloudWatchMetricName(t *testing.T) {
+	cases := map[string]string{
+		"monitor_success": *cloudwatchSuccessMetric,
+		"monitor_failure": *cloudwatchFailureMetric,
+		"monitor_pull":    *cloudwatchPullTimeMetric,
+		"monitor_push":    *cloudwatchPushTimeMetric,
+		"monitor_unknown": "",
+	}
+
+	for name, want := range cases {
+		if got := cloudWatchMetricName(name); got != want {
+			t.Errorf("cloudWatchMetricName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTagsFromLabels(t *testing.T) {
+	tags := tagsFromLabels(map[string]string{"registry": "quay.io", "repository": "acme/app"})
+
+	want := map[string]bool{"registry:quay.io": true, "repository:acme/app": true}
+	if len(tags) != len(want) {
+		t.Fatalf("tagsFromLabels returned %d tags, want %d", len(tags), len(want))
+	}
+	for _, tag := range tags {
+		if !want[tag] {
+			t.Errorf("unexpected tag %q", tag)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/containers/podman/v3/pkg/bindings"
 	"github.com/containers/podman/v3/pkg/bindings/containers"
 	"github.com/containers/podman/v3/pkg/bindings/images"
+	"github.com/containers/podman/v3/pkg/bindings/manifests"
 	"github.com/containers/podman/v3/pkg/specgen"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/coreos/pkg/flagutil"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -42,6 +44,22 @@ var publicBase = flag.Bool("public-base", false, "Is the base image public or pr
 var baseLayer = flag.String("base-layer-id", "", "Docker V1 ID of the base layer in the repository; instead of base-image")
 var testInterval = flag.String("run-test-every", "2m", "the time between test in minutes")
 
+var configPath = flag.String("config", "", "Path to a YAML file describing multiple targets (registry, repository, credentials secret, base-image, interval, platforms) to monitor concurrently; overrides the single-repository flags above")
+var maxConcurrent = flag.Int("max-concurrent", 4, "Maximum number of pull/push cycles allowed to run at once across all targets")
+
+var manifestListMode = flag.Bool("manifest-list", false, "Exercise a multi-architecture manifest list instead of a single image tag")
+var arches = flag.String("arches", "", "Comma-separated list of architectures to include in the manifest list (e.g. linux/amd64,linux/arm64)")
+
+var buildContextDir = flag.String("build-context", "", "Directory to use as a Containerfile build context; when set, pushes a real build instead of faking a new layer with the commit trick")
+var buildArgsFlag = flag.String("build-arg", "", "Comma-separated KEY=VALUE build arguments to pass to the --build-context Containerfile build")
+var buildPlatform = flag.String("build-platform", "", "Target platform for the --build-context build (e.g. linux/arm64), exercised via buildah's cross-arch emulation")
+var squashBuild = flag.Bool("squash", false, "Squash the --build-context build into a single layer before pushing")
+
+var verifySignatures = flag.Bool("verify-signatures", false, "Gate pulls on signature verification via a containers policy.json, sign each push with an ephemeral key, and verify the round trip against the registry")
+var policyPath = flag.String("policy-path", "", "Path to a containers policy.json used to gate pulls when --verify-signatures is set")
+var cosignPublicKey = flag.String("cosign-public-key", "", "Static cosign public key (PEM) to verify pushed image signatures against; takes precedence over --fulcio-root")
+var fulcioRoot = flag.String("fulcio-root", "", "Path to a Fulcio root certificate used for keyless signature verification when --cosign-public-key is not set")
+
 var awsAccessKey = flag.String("aws-access-key", "", "AWS Access Key for connecting to CloudWatch")
 var awsSecretKey = flag.String("aws-secret-key", "", "AWS Secret Key for connecting to CloudWatch")
 var cloudwatchRegion = flag.String("cloudwatch-region", "us-east-1", "Region in which to write the CloudWatch metrics")
@@ -51,12 +69,14 @@ var cloudwatchFailureMetric = flag.String("cloudwatch-metric-failure", "MonitorF
 var cloudwatchPullTimeMetric = flag.String("cloudwatch-metric-pull-time", "MonitorPullTime", "Name of the CloudWatch metric for pull timing")
 var cloudwatchPushTimeMetric = flag.String("cloudwatch-metric-push-time", "MonitorPushTime", "Name of the CloudWatch metric for push timing")
 
+var metricsSinkNames = flag.String("metrics-sink", "prometheus,cloudwatch", "Comma-separated list of metrics sinks to enable: prometheus, cloudwatch, otlp, statsd")
+var otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP gRPC endpoint to export metrics to (e.g. localhost:4317); required when otlp is in --metrics-sink")
+var otlpInsecure = flag.Bool("otlp-insecure", false, "Disable TLS when connecting to the OTLP endpoint")
+var statsdAddress = flag.String("statsd-address", "", "StatsD/DogStatsD address to send metrics to (e.g. localhost:8125); required when statsd is in --metrics-sink")
+
 var (
-	base         string
 	dockerClient *docker.Client
 	dockerHost   string
-	healthy      bool
-	status       bool
 	podmanContext context.Context
 )
 
@@ -68,31 +88,85 @@ var (
 		Subsystem: "",
 		Name:      "monitor_success",
 		Help:      "The registry monitor successfully completed a pull and push operation",
-	}, []string{})
+	}, []string{"registry", "repository"})
 
 	promFailureMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: promNamespace,
 		Subsystem: "",
 		Name:      "monitor_failure",
 		Help:      "The registry monitor failed to complete a pull and push operation",
-	}, []string{})
+	}, []string{"registry", "repository"})
 
-	promPushMetric = prometheus.NewSummary(prometheus.SummaryOpts{
+	promPushMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace: promNamespace,
 		Subsystem: "",
 		Name:      "monitor_push",
 		Help:      "The time for the monitor push operation",
-	})
+	}, []string{"registry", "repository"})
 
-	promPullMetric = prometheus.NewSummary(prometheus.SummaryOpts{
+	promPullMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace: promNamespace,
 		Subsystem: "",
 		Name:      "monitor_pull",
 		Help:      "The time for the monitor pull operation",
-	})
+	}, []string{"registry", "repository"})
+
+	promManifestPushMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_manifest_push",
+		Help:      "The time for the monitor manifest list push operation",
+	}, []string{"registry", "repository"})
+
+	promManifestArchMissingMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_manifest_arch_missing",
+		Help:      "A platform expected in the pushed manifest list was not listed when inspecting it, or the inspect failed",
+	}, []string{"registry", "repository", "arch"})
+
+	promBuildContextTarMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_build_context_tar",
+		Help:      "The time to tar the --build-context directory before handing it to the build binding",
+	}, []string{"registry", "repository"})
+
+	promBuildMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_build",
+		Help:      "The time for the --build-context Containerfile build",
+	}, []string{"registry", "repository"})
+
+	promBuildPushMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_build_push",
+		Help:      "The time to push the image produced by the --build-context Containerfile build",
+	}, []string{"registry", "repository"})
+
+	promSignatureVerifySuccessMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_signature_verify_success",
+		Help:      "The monitor signed a push and verified its signature round-tripped through the registry",
+	}, []string{"registry", "repository"})
+
+	promSignatureVerifyFailureMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "",
+		Name:      "monitor_signature_verify_failure",
+		Help:      "The monitor could not verify the pushed image's signature; reason is one of missing, bad_signature, untrusted_identity",
+	}, []string{"registry", "repository", "reason"})
 )
 
-var prometheusMetrics = []prometheus.Collector{promSuccessMetric, promFailureMetric, promPullMetric, promPushMetric}
+var prometheusMetrics = []prometheus.Collector{
+	promSuccessMetric, promFailureMetric, promPullMetric, promPushMetric,
+	promManifestPushMetric, promManifestArchMissingMetric,
+	promBuildContextTarMetric, promBuildMetric, promBuildPushMetric,
+	promSignatureVerifySuccessMetric, promSignatureVerifyFailureMetric,
+}
 
 type LoggingWriter struct{}
 
@@ -103,6 +177,7 @@ func (w *LoggingWriter) Write(p []byte) (n int, err error) {
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	healthy := aggregateHealthy()
 	if !healthy {
 		w.WriteHeader(503)
 	}
@@ -111,6 +186,7 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := aggregateStatus()
 	if !status {
 		w.WriteHeader(400)
 	}
@@ -146,12 +222,18 @@ func buildTLSTransport(basePath string) (*http.Transport, error) {
 	}, nil
 }
 
-func newPodmanClient() (context.Context, error) {
+// newPodmanClient binds to the podman socket with ctx as the connection's
+// parent context, so cancelling ctx (e.g. a DELETE /runs/{id}) is observed
+// by every podman binding call made through the returned context.
+func newPodmanClient(ctx context.Context, connectionURI string) (context.Context, error) {
 
-	socket := "ssh://vagrant@127.0.0.1:2222/run/user/1000/podman/podman.sock"
+	socket := connectionURI
+	if socket == "" {
+		socket = "ssh://vagrant@127.0.0.1:2222/run/user/1000/podman/podman.sock"
+	}
 	absPath, _ := filepath.Abs("opensshkey")
 
-	podmanContext, err := bindings.NewConnectionWithIdentity(context.Background(), socket, absPath)
+	podmanContext, err := bindings.NewConnectionWithIdentity(ctx, socket, absPath)
 	if err != nil {
 		return nil, err
 	}
@@ -265,18 +347,19 @@ func clearAllImages(dockerClient *docker.Client) bool {
 	return true
 }
 
-func pullTestImage(podmanContext context.Context) bool {
-	fullImagePath := imagePath(*repository)
+func pullTestImage(podmanContext context.Context, target *Target) bool {
+	fullImagePath := imagePath(target.Repository)
 	var pullOptions *images.PullOptions
-	if *publicBase {
+	if target.PublicBase {
 		pullOptions = &images.PullOptions{}
 	} else {
 		pullOptions = &images.PullOptions{
-			Username: username,
-			Password: password,
+			Username: &target.Username,
+			Password: &target.Password,
 		}
 	}
-	
+	applySignaturePolicy(pullOptions)
+
 	fmt.Println("Pulling test image...")
 	if _, err := images.Pull(podmanContext, fullImagePath, pullOptions); err != nil {
 		log.Errorf("Pull Error: %s", err)
@@ -303,17 +386,18 @@ func fullImageRef(registry, repository, baseImage string) string {
 	}
 }
 
-func pullBaseImage(podmanContext context.Context) bool {
-	fullImagePath := fullImageRef(*registryHost, *repository, *baseImage)
+func pullBaseImage(podmanContext context.Context, target *Target) bool {
+	fullImagePath := fullImageRef(target.Registry, target.Repository, target.BaseImage)
 	var pullOptions *images.PullOptions
-	if *publicBase {
+	if target.PublicBase {
 		pullOptions = &images.PullOptions{}
 	} else {
 		pullOptions = &images.PullOptions{
-			Username: username,
-			Password: password,
+			Username: &target.Username,
+			Password: &target.Password,
 		}
 	}
+	applySignaturePolicy(pullOptions)
 
 	if _, err := images.Pull(podmanContext, fullImagePath, pullOptions); err != nil {
 		log.Errorf("Pull Error: %s", err)
@@ -323,9 +407,9 @@ func pullBaseImage(podmanContext context.Context) bool {
 	return true
 }
 
-func deleteTopLayer(podmanContext context.Context) bool {
+func deleteTopLayer(podmanContext context.Context, target *Target) bool {
 	var historyOptions *images.HistoryOptions
-	imageHistory, err := images.History(podmanContext, *baseLayer, historyOptions)
+	imageHistory, err := images.History(podmanContext, target.BaseLayer, historyOptions)
 	if err != nil {
 		log.Errorf("%s", err)
 		return false
@@ -334,8 +418,7 @@ func deleteTopLayer(podmanContext context.Context) bool {
 	for _, image := range imageHistory {
 		if stringInSlice("latest", image.Tags) {
 			log.Infof("Deleting image %s", image.ID)
-			var imagesToRemove []string
-			imagesToRemove[0] = image.ID
+			imagesToRemove := []string{image.ID}
 			_, err := images.Remove(podmanContext, imagesToRemove, &images.RemoveOptions{})
 			if err != nil {
 				log.Errorf("%s", err)
@@ -345,15 +428,14 @@ func deleteTopLayer(podmanContext context.Context) bool {
 		}
 	}
 
-	return healthy
+	return true
 }
 
-func createTagLayer(podmanContext context.Context) bool {
+func createTagLayer(podmanContext context.Context, target *Target) bool {
 	container_name := fmt.Sprintf("updatedcontainer%v", time.Now().Unix())
 	log.Infof("Creating new image via container %v", container_name)
 
-	
-	s := specgen.NewSpecGenerator(fullImageRef(*registryHost, *repository, *baseImage), false)
+	s := specgen.NewSpecGenerator(fullImageRef(target.Registry, target.Repository, target.BaseImage), false)
 	s.Name = container_name
 	createdResponse, err := containers.CreateWithSpec(podmanContext, s, nil)
 	if err != nil {
@@ -386,13 +468,13 @@ func createTagLayer(podmanContext context.Context) bool {
 	return true
 }
 
-func pushTestImage(podmanContext context.Context) bool {
+func pushTestImage(podmanContext context.Context, target *Target) bool {
 	pushOptions := &images.PushOptions{
-		Username: username,
-		Password: password,
+		Username: &target.Username,
+		Password: &target.Password,
 	}
 
-	source := fullImageRef(*registryHost, *repository, "")
+	source := fullImageRef(target.Registry, target.Repository, "")
 	if err := images.Push(podmanContext, source, source, pushOptions); err != nil {
 		log.Errorf("Push Error: %s", err)
 		return false
@@ -401,11 +483,149 @@ func pushTestImage(podmanContext context.Context) bool {
 	return true
 }
 
+func parseArches() []string {
+	var list []string
+	for _, arch := range strings.Split(*arches, ",") {
+		arch = strings.TrimSpace(arch)
+		if arch != "" {
+			list = append(list, arch)
+		}
+	}
+	return list
+}
+
+// archImageRef returns the per-architecture image that the manifest list
+// entry for arch points at, e.g. "registry/repo:latest-linux-amd64".
+func archImageRef(target *Target, arch string) string {
+	sanitized := strings.ReplaceAll(arch, "/", "-")
+	return fmt.Sprintf("%s-%s", fullImageRef(target.Registry, target.Repository, target.BaseImage), sanitized)
+}
+
+func pullArchImages(podmanContext context.Context, target *Target, archList []string) bool {
+	for _, arch := range archList {
+		log.Infof("Pulling per-arch image for %s", arch)
+		pullOptions := &images.PullOptions{
+			Username: &target.Username,
+			Password: &target.Password,
+		}
+		if _, err := images.Pull(podmanContext, archImageRef(target, arch), pullOptions); err != nil {
+			log.Errorf("Pull Error for arch %s: %s", arch, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func createManifestListImage(podmanContext context.Context, target *Target, archList []string) (string, bool) {
+	manifestName := fullImageRef(target.Registry, target.Repository, target.BaseImage)
+
+	log.Infof("Creating manifest list %s", manifestName)
+	manifestID, err := manifests.Create(podmanContext, []string{manifestName}, []string{}, nil)
+	if err != nil {
+		log.Errorf("Error creating manifest list: %s", err)
+		return "", false
+	}
+
+	for _, arch := range archList {
+		log.Infof("Adding %s digest to manifest list", arch)
+		if _, err := manifests.Add(podmanContext, manifestID, &manifests.AddOptions{Images: []string{archImageRef(target, arch)}}); err != nil {
+			log.Errorf("Error adding %s to manifest list: %s", arch, err)
+			return "", false
+		}
+	}
+
+	return manifestID, true
+}
+
+func pushManifestListImage(podmanContext context.Context, target *Target, manifestID string) bool {
+	manifestName := fullImageRef(target.Registry, target.Repository, target.BaseImage)
+
+	if _, err := manifests.Push(podmanContext, manifestID, manifestName, &manifests.PushOptions{}); err != nil {
+		log.Errorf("Error pushing manifest list: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// verifyManifestList inspects the manifest list by name via manifests.Inspect
+// and checks that every expected OS/architecture pair is listed in it. It
+// does not pull the list back from the registry first, and it does not fetch
+// the per-platform digests.
+func verifyManifestList(sinks []MetricsSink, podmanContext context.Context, target *Target, archList []string) bool {
+	manifestName := fullImageRef(target.Registry, target.Repository, target.BaseImage)
+
+	reportArchMissing := func(arch string) {
+		labels := mergeLabels(target.labels(), map[string]string{"arch": arch})
+		for _, sink := range sinks {
+			sink.IncCounter("monitor_manifest_arch_missing", labels, 1)
+		}
+	}
+
+	list, err := manifests.Inspect(podmanContext, manifestName, nil)
+	if err != nil {
+		log.Errorf("Error inspecting pushed manifest list: %s", err)
+		for _, arch := range archList {
+			reportArchMissing(arch)
+		}
+		return false
+	}
+
+	found := map[string]bool{}
+	for _, entry := range list.Manifests {
+		if entry.Platform.OS != "" && entry.Platform.Architecture != "" {
+			found[fmt.Sprintf("%s/%s", entry.Platform.OS, entry.Platform.Architecture)] = true
+		}
+	}
+
+	allPresent := true
+	for _, arch := range archList {
+		if !found[arch] {
+			log.Errorf("Manifest list is missing expected platform %s", arch)
+			reportArchMissing(arch)
+			allPresent = false
+		}
+	}
+
+	return allPresent
+}
+
+// pushManifestListTest exercises the full multi-arch manifest list path:
+// pull each per-arch image, create the list locally and add each image, push
+// the assembled list, then inspect it and check every expected platform.
+func pushManifestListTest(sinks []MetricsSink, podmanContext context.Context, target *Target) bool {
+	archList := target.archList()
+	if len(archList) == 0 {
+		log.Errorf("--manifest-list requires --arches (or a config target's platforms) to be set")
+		return false
+	}
+
+	if !pullArchImages(podmanContext, target, archList) {
+		return false
+	}
+
+	manifestID, ok := createManifestListImage(podmanContext, target, archList)
+	if !ok {
+		return false
+	}
+
+	pushStartTime := time.Now()
+	if !pushManifestListImage(podmanContext, target, manifestID) {
+		return false
+	}
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_manifest_push", target.labels(), time.Since(pushStartTime))
+	}
+
+	return verifyManifestList(sinks, podmanContext, target, archList)
+}
+
 func init() {
 
 	fmt.Println("init")
 	var err error
-	_, err = newPodmanClient()
+	_, err = newPodmanClient(context.Background(), "")
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
@@ -434,35 +654,50 @@ func main() {
 
 	log.SetLevel(lvl)
 
-	// Ensure we have proper values.
-	if *username == "" {
-		log.Fatalln("Missing username flag")
-	}
+	var targets []Target
+	if *configPath != "" {
+		log.Infof("Loading targets from %s", *configPath)
+		loaded, err := loadTargets(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load --config: %v", err)
+		}
+		if len(loaded) == 0 {
+			log.Fatalln("--config file does not define any targets")
+		}
+		targets = loaded
+	} else {
+		// Ensure we have proper values.
+		if *username == "" {
+			log.Fatalln("Missing username flag")
+		}
 
-	if *password == "" {
-		log.Fatalln("Missing password flag")
-	}
+		if *password == "" {
+			log.Fatalln("Missing password flag")
+		}
 
-	if *registryHost == "" {
-		log.Fatalln("Missing registry-host flag")
-	}
+		if *registryHost == "" {
+			log.Fatalln("Missing registry-host flag")
+		}
 
-	if *repository == "" {
-		log.Fatalln("Missing repository flag")
-	}
+		if *repository == "" {
+			log.Fatalln("Missing repository flag")
+		}
 
-	// TODO 
-	if *baseImage == "" && *baseLayer == "" {
-		log.Infoln("Missing base-image and base-layer-id flag; Dynamically assigning base-layer-id")
-		grabID, err := images.History(podmanContext, *repository, &images.HistoryOptions{})
-		// grabID, err := dockerClient.ImageHistory(*repository)
-		if err != nil {
-			log.Fatalf("Failed to grab image ID: %v", err)
+		// TODO
+		if *baseImage == "" && *baseLayer == "" {
+			log.Infoln("Missing base-image and base-layer-id flag; Dynamically assigning base-layer-id")
+			grabID, err := images.History(podmanContext, *repository, &images.HistoryOptions{})
+			// grabID, err := dockerClient.ImageHistory(*repository)
+			if err != nil {
+				log.Fatalf("Failed to grab image ID: %v", err)
+			}
+			log.Infof("Assigning base-layer-id to %s", grabID[0].ID)
+			*baseLayer = grabID[0].ID
+		} else if *baseImage != "" && *baseLayer != "" {
+			log.Fatalln("Both base-image and base-layer-id flag; only one of required")
 		}
-		log.Infof("Assigning base-layer-id to %s", grabID[0].ID)
-		*baseLayer = grabID[0].ID
-	} else if *baseImage != "" && *baseLayer != "" {
-		log.Fatalln("Both base-image and base-layer-id flag; only one of required")
+
+		targets = []Target{targetFromFlags()}
 	}
 
 	// Register the metrics.
@@ -474,25 +709,47 @@ func main() {
 	}
 
 	// Setup the HTTP server.
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/status", statusHandler)
+	router := mux.NewRouter()
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/health", healthHandler)
+	router.HandleFunc("/status", statusHandler)
+	registerRunsAPI(router)
 
 	log.Infoln("Listening on", *listen)
 
-	// Run the monitor routine.
-	runMonitor()
+	var cloudwatchService *cloudwatch.CloudWatch
+	if *awsAccessKey != "" && *awsSecretKey != "" && *cloudwatchNamespace != "" {
+		log.Infof("Configuring CloudWatch metrics reporting")
+		aws_creds := credentials.NewStaticCredentials(*awsAccessKey, *awsSecretKey, "")
+		sess, _ := session.NewSession(&aws.Config{Region: aws.String(*cloudwatchRegion), Credentials: aws_creds})
+		cloudwatchService = cloudwatch.New(sess)
+	}
+
+	sinks := buildMetricsSinks(strings.Split(*metricsSinkNames, ","), cloudwatchService)
+
+	// Run the monitor routine, one goroutine per target.
+	runMonitor(sinks, targets)
 
 	// Listen and serve.
 	fmt.Println("listen and server")
-	log.Fatal(http.ListenAndServe(*listen, nil))
+	log.Fatal(http.ListenAndServe(*listen, router))
 }
 
-func putCloudWatchMetric(metricName string, watchService *cloudwatch.CloudWatch, unitName string, metricValue float64) {
+func putCloudWatchMetric(metricName string, watchService *cloudwatch.CloudWatch, unitName string, metricValue float64, labels map[string]string) {
 	if watchService == nil {
 		return
 	}
 
+	var dimensions []*cloudwatch.Dimension
+	for _, key := range []string{"registry", "repository"} {
+		if value, ok := labels[key]; ok && value != "" {
+			dimensions = append(dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(key),
+				Value: aws.String(value),
+			})
+		}
+	}
+
 	params := &cloudwatch.PutMetricDataInput{
 		MetricData: []*cloudwatch.MetricDatum{
 			&cloudwatch.MetricDatum{
@@ -500,6 +757,7 @@ func putCloudWatchMetric(metricName string, watchService *cloudwatch.CloudWatch,
 				Timestamp:  aws.Time(time.Now()),
 				Unit:       aws.String(unitName),
 				Value:      aws.Float64(metricValue),
+				Dimensions: dimensions,
 			},
 		},
 		Namespace: aws.String(*cloudwatchNamespace),
@@ -512,119 +770,90 @@ func putCloudWatchMetric(metricName string, watchService *cloudwatch.CloudWatch,
 	log.Printf("Reports to cloudwatch success")
 }
 
-func reportSuccess(watchService *cloudwatch.CloudWatch) {
-	status = true
-	m, err := promSuccessMetric.GetMetricWithLabelValues()
-	if err != nil {
-		panic(err)
+func (m *Monitor) reportSuccess(sinks []MetricsSink, labels map[string]string) {
+	m.setStatus(true)
+	for _, sink := range sinks {
+		sink.IncCounter("monitor_success", labels, 1)
 	}
-	m.Inc()
-	putCloudWatchMetric(*cloudwatchSuccessMetric, watchService, "Count", 1)
 }
 
-func reportFailure(watchService *cloudwatch.CloudWatch) {
-	status = false
-	m, err := promFailureMetric.GetMetricWithLabelValues()
-	if err != nil {
-		panic(err)
+func (m *Monitor) reportFailure(sinks []MetricsSink, labels map[string]string) {
+	m.setStatus(false)
+	for _, sink := range sinks {
+		sink.IncCounter("monitor_failure", labels, 1)
 	}
-	m.Inc()
-	putCloudWatchMetric(*cloudwatchFailureMetric, watchService, "Count", 1)
 }
 
-func reportPushTime(watchService *cloudwatch.CloudWatch, duration time.Duration) {
-	promPushMetric.Observe(duration.Seconds())
-	putCloudWatchMetric(*cloudwatchPushTimeMetric, watchService, "Seconds", duration.Seconds())
+func reportPushTime(sinks []MetricsSink, labels map[string]string, duration time.Duration) {
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_push", labels, duration)
+	}
 }
 
-func reportPullTime(watchService *cloudwatch.CloudWatch, duration time.Duration) {
-	promPullMetric.Observe(duration.Seconds())
-	putCloudWatchMetric(*cloudwatchPullTimeMetric, watchService, "Seconds", duration.Seconds())
+func reportPullTime(sinks []MetricsSink, labels map[string]string, duration time.Duration) {
+	for _, sink := range sinks {
+		sink.ObserveDuration("monitor_pull", labels, duration)
+	}
 }
 
-func runMonitor() {
-	firstLoop := true
-	healthy = true
-	duration := 120 * time.Second
-	mainLoop := func() {
-		userDuration, err := time.ParseDuration(*testInterval)
-		if err != nil {
-			log.Fatalf("Failed to parse time interval: %v", err)
-		}
-
-		var cloudwatchService *cloudwatch.CloudWatch
-		if *awsAccessKey != "" && *awsSecretKey != "" && *cloudwatchNamespace != "" {
-			log.Infof("Configuring CloudWatch metrics reporting")
-			aws_creds := credentials.NewStaticCredentials(*awsAccessKey, *awsSecretKey, "")
-			sess, _ := session.NewSession(&aws.Config{Region: aws.String(*cloudwatchRegion), Credentials: aws_creds})
-			cloudwatchService = cloudwatch.New(sess)
-		}
-
-		for {
-			if !firstLoop {
-				log.Infof("Sleeping for %v", duration)
-				time.Sleep(duration)
-			}
-
-			log.Infof("Starting test")
-			firstLoop = false
-			status = true
-
-			podmanContext, err = newPodmanClient()
-
-			log.Infof("Pulling test image")
-			pullStartTime := time.Now()
-			if !pullTestImage(podmanContext) {
-				duration = 30 * time.Second
-				reportFailure(cloudwatchService)
-				continue
-			}
+// targetStaggerInterval spaces out each target's first run so a fleet of
+// targets doesn't all hit the registry in the same instant.
+const targetStaggerInterval = 5 * time.Second
 
-			// Write the pull time metric.
-			reportPullTime(cloudwatchService, time.Since(pullStartTime))
+func runMonitor(sinks []MetricsSink, targets []Target) {
+	workLimiter := make(chan struct{}, *maxConcurrent)
 
-			if *baseImage != "" {
-				log.Infof("Pulling specified base image")
-				if !pullBaseImage(podmanContext) {
-					healthy = false
-					return
-				}
+	for i := range targets {
+		monitor := NewMonitor(targets[i], sinks, workLimiter)
+		registerMonitor(monitor)
+		startDelay := time.Duration(i) * targetStaggerInterval
+		go monitor.runSupervised(startDelay)
+	}
+}
 
-				base = *baseImage
-			} else {
-				base = *baseLayer
-			}
+// runSupervised recovers from any panic in runPeriodically so a bug in one
+// target doesn't take down monitoring for the rest of the fleet.
+func (m *Monitor) runSupervised(startDelay time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("[%s/%s] monitor goroutine panicked: %v", m.Target.Registry, m.Target.Repository, r)
+			m.setHealthy(false)
+		}
+	}()
+	m.runPeriodically(startDelay)
+}
 
-			log.Infof("Deleting top layer")
-			if !deleteTopLayer(podmanContext) {
-				healthy = false
-				return
-			}
+// runPeriodically drives m.Run on a timer, sleeping the configured interval
+// between successful cycles and backing off after a retryable failure. It
+// stops for good if a cycle hits a non-retryable (Fatal) error.
+func (m *Monitor) runPeriodically(startDelay time.Duration) {
+	if startDelay > 0 {
+		time.Sleep(startDelay)
+	}
 
-			log.Infof("Creating new top layer")
-			if !createTagLayer(podmanContext) {
-				healthy = false
-				return
-			}
+	firstLoop := true
+	duration := 120 * time.Second
 
-			log.Infof("Pushing test image")
-			pushStartTime := time.Now()
-			if !pushTestImage(podmanContext) {
-				duration = 30 * time.Second
-				reportFailure(cloudwatchService)
-				continue
-			}
+	for {
+		if !firstLoop {
+			log.Infof("[%s/%s] Sleeping for %v", m.Target.Registry, m.Target.Repository, duration)
+			time.Sleep(duration)
+		}
 
-			// Write the push time metric.
-			reportPushTime(cloudwatchService, time.Since(pushStartTime))
+		log.Infof("[%s/%s] Starting test", m.Target.Registry, m.Target.Repository)
+		firstLoop = false
+		m.setStatus(true)
 
-			log.Infof("Test successful")
-			duration = userDuration
+		result := m.Run(context.Background())
 
-			// Write the success metric.
-			reportSuccess(cloudwatchService)
+		switch {
+		case result.Success:
+			duration = m.Target.Interval
+		case result.Fatal:
+			m.setHealthy(false)
+			return
+		default:
+			duration = 30 * time.Second
 		}
 	}
-
-	go mainLoop()
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArches(t *testing.T) {
+	original := *arches
+	defer func() { *arches = original }()
+
+	*arches = " linux/amd64 ,linux/arm64,, "
+	got := parseArches()
+	want := []string{"linux/amd64", "linux/arm64"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseArches = %#v, want %#v", got, want)
+	}
+
+	*arches = ""
+	if got := parseArches(); len(got) != 0 {
+		t.Errorf("parseArches(\"\") = %#v, want empty", got)
+	}
+}
+
+func TestArchImageRef(t *testing.T) {
+	target := &Target{Registry: "quay.io", Repository: "acme/app"}
+
+	got := archImageRef(target, "linux/arm64")
+	want := "quay.io/acme/app:latest-linux-arm64"
+	if got != want {
+		t.Errorf("archImageRef = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// runHistoryLimit bounds the ring buffer of past runs kept per target.
+const runHistoryLimit = 50
+
+// RunResult records one monitor cycle: when it ran, how long each phase
+// took, and whether it succeeded.
+type RunResult struct {
+	ID         int               `json:"id"`
+	Registry   string            `json:"registry"`
+	Repository string            `json:"repository"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
+	Durations  map[string]string `json:"durations"`
+	Success    bool              `json:"success"`
+	Cancelled  bool              `json:"cancelled,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Fatal      bool              `json:"-"`
+}
+
+// Monitor drives the pull/delete/commit/push cycle for a single Target. The
+// periodic timer loop and the on-demand HTTP handlers both call Run, and mu
+// makes sure the two never execute a cycle against this target at once.
+type Monitor struct {
+	Target      Target
+	Sinks       []MetricsSink
+	workLimiter chan struct{}
+
+	mu sync.Mutex
+
+	historyMu sync.Mutex
+	history   []*RunResult
+	cancels   map[int]context.CancelFunc
+
+	stateMu sync.Mutex
+	healthy bool
+	status  bool
+}
+
+func NewMonitor(target Target, sinks []MetricsSink, workLimiter chan struct{}) *Monitor {
+	return &Monitor{
+		Target:      target,
+		Sinks:       sinks,
+		workLimiter: workLimiter,
+		cancels:     map[int]context.CancelFunc{},
+		healthy:     true,
+		status:      true,
+	}
+}
+
+// setHealthy and setStatus back the /health and /status endpoints with
+// per-target state.
+func (m *Monitor) setHealthy(v bool) {
+	m.stateMu.Lock()
+	m.healthy = v
+	m.stateMu.Unlock()
+}
+
+func (m *Monitor) isHealthy() bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.healthy
+}
+
+func (m *Monitor) setStatus(v bool) {
+	m.stateMu.Lock()
+	m.status = v
+	m.stateMu.Unlock()
+}
+
+func (m *Monitor) isStatus() bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.status
+}
+
+// Run executes one monitor cycle against m.Target and blocks until it
+// finishes or ctx is cancelled; while the work limiter is full it waits for
+// a slot, giving up only if ctx is cancelled first.
+func (m *Monitor) Run(ctx context.Context) *RunResult {
+	return m.runWithTarget(ctx, m.Target)
+}
+
+// runWithTarget executes one monitor cycle against an explicit target,
+// letting the on-demand HTTP handler override the repository/base-image
+// without touching m.Target.
+func (m *Monitor) runWithTarget(ctx context.Context, target Target) *RunResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	result := &RunResult{
+		ID:         allocateRunID(),
+		Registry:   target.Registry,
+		Repository: target.Repository,
+		StartedAt:  time.Now(),
+		Durations:  map[string]string{},
+	}
+
+	m.historyMu.Lock()
+	m.cancels[result.ID] = cancel
+	m.historyMu.Unlock()
+
+	// Publish result only once the cycle is fully finished.
+	defer func() {
+		m.historyMu.Lock()
+		m.history = append(m.history, result)
+		if len(m.history) > runHistoryLimit {
+			m.history = m.history[len(m.history)-runHistoryLimit:]
+		}
+		delete(m.cancels, result.ID)
+		m.historyMu.Unlock()
+		cancel()
+	}()
+
+	select {
+	case m.workLimiter <- struct{}{}:
+	case <-runCtx.Done():
+		result.FinishedAt = time.Now()
+		result.Cancelled = true
+		result.Error = runCtx.Err().Error()
+		return result
+	}
+	defer func() { <-m.workLimiter }()
+
+	labels := target.labels()
+
+	podmanContext, err := newPodmanClient(runCtx, target.ConnectionURI)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create podman client: %s", err)
+		result.FinishedAt = time.Now()
+		m.reportFailure(m.Sinks, labels)
+		return result
+	}
+
+	if *manifestListMode {
+		start := time.Now()
+		ok := pushManifestListTest(m.Sinks, podmanContext, &target)
+		result.Durations["manifest"] = time.Since(start).String()
+		result.Success = ok
+		if !ok {
+			result.Error = "manifest list test failed"
+			m.reportFailure(m.Sinks, labels)
+		} else {
+			m.reportSuccess(m.Sinks, labels)
+		}
+		result.FinishedAt = time.Now()
+		return result
+	}
+
+	pullStart := time.Now()
+	if !pullTestImage(podmanContext, &target) {
+		result.Durations["pull"] = time.Since(pullStart).String()
+		result.Error = "pull failed"
+		result.FinishedAt = time.Now()
+		m.reportFailure(m.Sinks, labels)
+		return result
+	}
+	result.Durations["pull"] = time.Since(pullStart).String()
+	reportPullTime(m.Sinks, labels, time.Since(pullStart))
+
+	if target.BaseImage != "" {
+		if !pullBaseImage(podmanContext, &target) {
+			result.Error = "base image pull failed"
+			result.Fatal = true
+			result.FinishedAt = time.Now()
+			return result
+		}
+	}
+
+	if *buildContextDir != "" {
+		if !buildAndPushImage(m.Sinks, podmanContext, &target, result) {
+			result.FinishedAt = time.Now()
+			m.reportFailure(m.Sinks, labels)
+			return result
+		}
+
+		if *verifySignatures {
+			verifySignatureRoundTrip(m.Sinks, podmanContext, &target, result)
+		}
+
+		result.Success = true
+		result.FinishedAt = time.Now()
+		m.reportSuccess(m.Sinks, labels)
+		return result
+	}
+
+	deleteStart := time.Now()
+	if !deleteTopLayer(podmanContext, &target) {
+		result.Durations["delete"] = time.Since(deleteStart).String()
+		result.Error = "delete top layer failed"
+		result.Fatal = true
+		result.FinishedAt = time.Now()
+		return result
+	}
+	result.Durations["delete"] = time.Since(deleteStart).String()
+
+	commitStart := time.Now()
+	if !createTagLayer(podmanContext, &target) {
+		result.Durations["commit"] = time.Since(commitStart).String()
+		result.Error = "commit failed"
+		result.Fatal = true
+		result.FinishedAt = time.Now()
+		return result
+	}
+	result.Durations["commit"] = time.Since(commitStart).String()
+
+	pushStart := time.Now()
+	if !pushTestImage(podmanContext, &target) {
+		result.Durations["push"] = time.Since(pushStart).String()
+		result.Error = "push failed"
+		result.FinishedAt = time.Now()
+		m.reportFailure(m.Sinks, labels)
+		return result
+	}
+	result.Durations["push"] = time.Since(pushStart).String()
+	reportPushTime(m.Sinks, labels, time.Since(pushStart))
+
+	if *verifySignatures {
+		verifySignatureRoundTrip(m.Sinks, podmanContext, &target, result)
+	}
+
+	result.Success = true
+	result.FinishedAt = time.Now()
+	m.reportSuccess(m.Sinks, labels)
+	return result
+}
+
+var (
+	runIDMu   sync.Mutex
+	nextRunID int
+)
+
+func allocateRunID() int {
+	runIDMu.Lock()
+	defer runIDMu.Unlock()
+	nextRunID++
+	return nextRunID
+}
+
+var (
+	monitorsMu         sync.Mutex
+	registeredMonitors []*Monitor
+)
+
+func registerMonitor(m *Monitor) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	registeredMonitors = append(registeredMonitors, m)
+}
+
+// aggregateHealthy reports false if any registered monitor has latched
+// unhealthy (hit a Fatal error).
+func aggregateHealthy() bool {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for _, m := range registeredMonitors {
+		if !m.isHealthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregateStatus reports false if any registered monitor's most recent
+// cycle reported a failure via reportFailure. Cycles that end in a Fatal
+// error or are cancelled before starting leave the status unchanged.
+func aggregateStatus() bool {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for _, m := range registeredMonitors {
+		if !m.isStatus() {
+			return false
+		}
+	}
+	return true
+}
+
+func targetKeyOf(t Target) string {
+	return fmt.Sprintf("%s/%s", t.Registry, t.Repository)
+}
+
+// findMonitor returns the monitor matching targetKey ("registry/repository"),
+// or the first registered monitor when targetKey is empty.
+func findMonitor(targetKey string) *Monitor {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	if targetKey == "" {
+		if len(registeredMonitors) == 0 {
+			return nil
+		}
+		return registeredMonitors[0]
+	}
+
+	for _, m := range registeredMonitors {
+		if targetKeyOf(m.Target) == targetKey {
+			return m
+		}
+	}
+	return nil
+}
+
+func findRun(id int) *RunResult {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for _, m := range registeredMonitors {
+		m.historyMu.Lock()
+		for _, result := range m.history {
+			if result.ID == id {
+				m.historyMu.Unlock()
+				return result
+			}
+		}
+		m.historyMu.Unlock()
+	}
+	return nil
+}
+
+func findCancel(id int) context.CancelFunc {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for _, m := range registeredMonitors {
+		m.historyMu.Lock()
+		cancel, ok := m.cancels[id]
+		m.historyMu.Unlock()
+		if ok {
+			return cancel
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to encode JSON response: %s", err)
+	}
+}
+
+func handleListRuns(w http.ResponseWriter, r *http.Request) {
+	targetKey := r.URL.Query().Get("target")
+
+	var results []*RunResult
+	monitorsMu.Lock()
+	for _, m := range registeredMonitors {
+		if targetKey != "" && targetKeyOf(m.Target) != targetKey {
+			continue
+		}
+		m.historyMu.Lock()
+		results = append(results, m.history...)
+		m.historyMu.Unlock()
+	}
+	monitorsMu.Unlock()
+
+	writeJSON(w, results)
+}
+
+func handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+
+	result := findRun(id)
+	if result == nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+func handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+
+	cancel := findCancel(id)
+	if cancel == nil {
+		http.Error(w, "run not found or already finished", http.StatusNotFound)
+		return
+	}
+
+	cancel()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type runOverrides struct {
+	Repository string `json:"repository"`
+	BaseImage  string `json:"baseImage"`
+}
+
+func handleTriggerRun(w http.ResponseWriter, r *http.Request) {
+	monitor := findMonitor(r.URL.Query().Get("target"))
+	if monitor == nil {
+		http.Error(w, "no targets configured", http.StatusNotFound)
+		return
+	}
+
+	var overrides runOverrides
+	if r.Body != nil {
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	target := monitor.Target
+	if overrides.Repository != "" {
+		target.Repository = overrides.Repository
+	}
+	if overrides.BaseImage != "" {
+		target.BaseImage = overrides.BaseImage
+	}
+
+	writeJSON(w, monitor.runWithTarget(r.Context(), target))
+}
+
+// registerRunsAPI wires the on-demand run and history endpoints onto router.
+func registerRunsAPI(router *mux.Router) {
+	router.HandleFunc("/runs", handleListRuns).Methods(http.MethodGet)
+	router.HandleFunc("/runs", handleTriggerRun).Methods(http.MethodPost)
+	router.HandleFunc("/runs/{id}", handleGetRun).Methods(http.MethodGet)
+	router.HandleFunc("/runs/{id}", handleCancelRun).Methods(http.MethodDelete)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Target describes a single repository to pull and push against, whether it
+// came from the single-repository command line flags or from one entry of
+// a --config fleet file.
+type Target struct {
+	Registry      string
+	Repository    string
+	Username      string
+	Password      string
+	PublicBase    bool
+	BaseImage     string
+	BaseLayer     string
+	Interval      time.Duration
+	Platforms     []string
+	ConnectionURI string
+}
+
+// targetConfig is the YAML shape of a single entry in a --config file.
+type targetConfig struct {
+	Registry          string   `yaml:"registry"`
+	Repository        string   `yaml:"repository"`
+	CredentialsSecret string   `yaml:"credentialsSecret"`
+	PublicBase        bool     `yaml:"publicBase"`
+	BaseImage         string   `yaml:"baseImage"`
+	Interval          string   `yaml:"interval"`
+	Platforms         []string `yaml:"platforms"`
+	ConnectionURI     string   `yaml:"connectionUri"`
+}
+
+type fleetConfig struct {
+	Targets []targetConfig `yaml:"targets"`
+}
+
+// resolveCredentials reads "user:pass" out of the environment variable
+// named by secretName.
+func resolveCredentials(secretName string) (string, string) {
+	if secretName == "" {
+		return "", ""
+	}
+
+	raw := os.Getenv(secretName)
+	parts := strings.SplitN(raw, ":", 2)
+	if len(parts) != 2 {
+		log.Warnf("Credentials secret %q is not in user:pass format", secretName)
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// loadTargets reads a --config YAML file describing N targets to monitor
+// concurrently.
+func loadTargets(path string) ([]Target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg fleetConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	targets := make([]Target, 0, len(cfg.Targets))
+	for _, raw := range cfg.Targets {
+		interval, err := time.ParseDuration(raw.Interval)
+		if err != nil {
+			log.Warnf("Target %s/%s has no valid interval; defaulting to 2m", raw.Registry, raw.Repository)
+			interval = 2 * time.Minute
+		}
+
+		user, pass := resolveCredentials(raw.CredentialsSecret)
+
+		targets = append(targets, Target{
+			Registry:      raw.Registry,
+			Repository:    raw.Repository,
+			Username:      user,
+			Password:      pass,
+			PublicBase:    raw.PublicBase,
+			BaseImage:     raw.BaseImage,
+			Interval:      interval,
+			Platforms:     raw.Platforms,
+			ConnectionURI: raw.ConnectionURI,
+		})
+	}
+
+	return targets, nil
+}
+
+// targetFromFlags builds the single Target implied by the legacy
+// single-repository command line flags, used when --config is not set.
+func targetFromFlags() Target {
+	interval, err := time.ParseDuration(*testInterval)
+	if err != nil {
+		log.Fatalf("Failed to parse time interval: %v", err)
+	}
+
+	return Target{
+		Registry:   *registryHost,
+		Repository: *repository,
+		Username:   *username,
+		Password:   *password,
+		PublicBase: *publicBase,
+		BaseImage:  *baseImage,
+		BaseLayer:  *baseLayer,
+		Interval:   interval,
+	}
+}
+
+// archList returns the platforms this target's manifest list should cover,
+// preferring the config file's platforms and falling back to --arches.
+func (t *Target) archList() []string {
+	if len(t.Platforms) > 0 {
+		return t.Platforms
+	}
+	return parseArches()
+}
+
+func (t *Target) labels() map[string]string {
+	return map[string]string{"registry": t.Registry, "repository": t.Repository}
+}
+
+// mergeLabels returns a new label set combining base with extra, without
+// mutating either input.
+func mergeLabels(base, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	const secretName = "REGISTRY_MONITOR_TEST_SECRET"
+
+	if user, pass := resolveCredentials(""); user != "" || pass != "" {
+		t.Fatalf("resolveCredentials(\"\") = %q, %q, want empty", user, pass)
+	}
+
+	os.Setenv(secretName, "alice:hunter2")
+	defer os.Unsetenv(secretName)
+
+	user, pass := resolveCredentials(secretName)
+	if user != "alice" || pass != "hunter2" {
+		t.Fatalf("resolveCredentials(%q) = %q, %q, want alice, hunter2", secretName, user, pass)
+	}
+
+	os.Setenv(secretName, "not-in-user-pass-format")
+	if user, pass := resolveCredentials(secretName); user != "" || pass != "" {
+		t.Fatalf("resolveCredentials(malformed) = %q, %q, want empty", user, pass)
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	const secretName = "REGISTRY_MONITOR_TEST_TARGETS_SECRET"
+	os.Setenv(secretName, "bob:swordfish")
+	defer os.Unsetenv(secretName)
+
+	yamlContent := `
+targets:
+  - registry: quay.io
+    repository: acme/app
+    credentialsSecret: ` + secretName + `
+    interval: 90s
+  - registry: ecr.example.com
+    repository: acme/other
+    interval: not-a-duration
+`
+
+	f, err := ioutil.TempFile("", "monitor-config-*.yaml")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(yamlContent); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	f.Close()
+
+	targets, err := loadTargets(f.Name())
+	if err != nil {
+		t.Fatalf("loadTargets: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("loadTargets returned %d targets, want 2", len(targets))
+	}
+
+	first := targets[0]
+	if first.Registry != "quay.io" || first.Repository != "acme/app" {
+		t.Errorf("first target = %+v, want registry quay.io, repository acme/app", first)
+	}
+	if first.Username != "bob" || first.Password != "swordfish" {
+		t.Errorf("first target credentials = %q/%q, want bob/swordfish", first.Username, first.Password)
+	}
+	if first.Interval != 90*time.Second {
+		t.Errorf("first target interval = %v, want 90s", first.Interval)
+	}
+
+	if targets[1].Interval != 2*time.Minute {
+		t.Errorf("second target interval = %v, want the 2m fallback for an invalid duration", targets[1].Interval)
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	base := map[string]string{"registry": "quay.io", "repository": "acme/app"}
+	extra := map[string]string{"arch": "linux/amd64"}
+
+	merged := mergeLabels(base, extra)
+
+	if len(base) != 2 || len(extra) != 1 {
+		t.Fatalf("mergeLabels mutated its inputs: base=%v extra=%v", base, extra)
+	}
+	if merged["registry"] != "quay.io" || merged["repository"] != "acme/app" || merged["arch"] != "linux/amd64" {
+		t.Fatalf("mergeLabels = %v, missing expected keys", merged)
+	}
+}